@@ -10,85 +10,113 @@
 package channelsync
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"regexp"
-
-	"github.com/couchbaselabs/go-couchbase"
-	"github.com/dchest/passwordhash"
 )
 
-/** Persistent information about a user. */
-type User struct {
-	Name         string                     `json:"name,omitempty"`
-	PasswordHash *passwordhash.PasswordHash `json:"passwordhash,omitempty"`
-	Channels     []string                   `json:"channels"`
-	
-	Password	 *string					`json:"password,omitempty"`
-}
-
-/** Manages user authentication for a database. */
-type Authenticator struct {
-	bucket *couchbase.Bucket
-}
+// A user's per-channel access grants: maps channel name to the ChannelGrant describing what
+// permission was granted and at what sequence.
+type ChannelGrants map[string]ChannelGrant
 
-// Creates a new Authenticator that stores user info in the given Bucket.
-func NewAuthenticator(bucket *couchbase.Bucket) *Authenticator {
-	return &Authenticator{
-		bucket: bucket,
+// Returns the grant that governs the given channel: the exact-channel entry if one exists
+// (even PermissionDeny), falling back to the "*" wildcard grant only when there's none.
+// This ensures an explicit deny on a channel always overrides a wildcard grant.
+func (grants ChannelGrants) effectiveGrant(channel string) ChannelGrant {
+	if grant, exists := grants[channel]; exists {
+		return grant
 	}
+	return grants["*"]
 }
 
-func docIDForUser(username string) string {
-	return "user:" + username
+/** Persistent information about a user. */
+type User struct {
+	Name         string        `json:"name,omitempty"`
+	PasswordHash *PasswordHash `json:"passwordhash,omitempty"`
+	Channels     ChannelGrants `json:"channels"`
+	Role         UserRole      `json:"role,omitempty"`
+	RoleNames    []string      `json:"roles,omitempty"`
+
+	Password *string `json:"password,omitempty"`
+
+	// auth is the Authenticator that loaded this User, used by InheritedChannels to resolve
+	// RoleNames. It's set by GetUser and not persisted.
+	auth Authenticator
+	// inheritedChannels caches the result of InheritedChannels for the lifetime of this User.
+	inheritedChannels ChannelGrants
 }
 
-// Looks up the information for a user.
-// If the username is "" it will return the default (guest) User object, not nil.
-// By default the guest User has access to everything, i.e. Admin Party! This can
-// be changed by altering its list of channels and saving the changes via SetUser.
-func (auth *Authenticator) GetUser(username string) (*User, error) {
-	var user *User
-	err := auth.bucket.Get(docIDForUser(username), &user)
-	if user == nil && username == "" {
-		return &User{Name: username, Channels: []string{"*"}}, nil
-	}
-	return user, err
+// Associates this User with the Authenticator that loaded it, so InheritedChannels can
+// resolve its roles. Called by Authenticator implementations; not for general use.
+func (user *User) SetAuthenticator(auth Authenticator) {
+	user.auth = auth
 }
 
-// Saves the information for a user.
-func (auth *Authenticator) SaveUser(user *User) error {
-	if user.Password != nil {
-		user.SetPassword(*user.Password)
-		user.Password = nil
+// Unmarshals a User, transparently upgrading older on-disk formats:
+//   - "channels" as a flat name list (`["a","b"]`, pre-permission-model) or a map of plain
+//     Permission strings (`{"a":"read-write"}`, pre-TimedSet) both imply a grant at sequence 0.
+//   - "passwordhash" as a raw dchest/passwordhash value (pre-PasswordHasher) is wrapped as
+//     the tagged `{"algo":"legacy","hash":...}` envelope, so legacyHasher can still verify it.
+func (user *User) UnmarshalJSON(data []byte) error {
+	type aliasUser User
+	var legacy struct {
+		aliasUser
+		Channels     json.RawMessage `json:"channels"`
+		PasswordHash json.RawMessage `json:"passwordhash"`
 	}
-	if err := user.Validate(); err != nil {
+	if err := json.Unmarshal(data, &legacy); err != nil {
 		return err
 	}
-	return auth.bucket.Set(docIDForUser(user.Name), 0, user)
-}
-
-// Deletes a user.
-func (auth *Authenticator) DeleteUser(username string) error {
-	return auth.bucket.Delete(docIDForUser(username))
-}
-
-// Authenticates a user given the username and password.
-// If the username and password are both "", it will return a default empty User object, not nil.
-func (auth *Authenticator) AuthenticateUser(username string, password string) *User {
-	user, _ := auth.GetUser(username)
-	if user == nil || !user.Authenticate(password) {
+	*user = User(legacy.aliasUser)
+	user.PasswordHash = nil
+	if len(legacy.PasswordHash) > 0 && string(legacy.PasswordHash) != "null" {
+		var envelope PasswordHash
+		if err := json.Unmarshal(legacy.PasswordHash, &envelope); err == nil && envelope.Algo != "" {
+			user.PasswordHash = &envelope
+		} else {
+			user.PasswordHash = &PasswordHash{Algo: algoLegacy, Hash: legacy.PasswordHash}
+		}
+	}
+	if len(legacy.Channels) == 0 {
 		return nil
 	}
-	return user
+	var grants ChannelGrants
+	if err := json.Unmarshal(legacy.Channels, &grants); err == nil {
+		user.Channels = grants
+		return nil
+	}
+	var permissions map[string]Permission
+	if err := json.Unmarshal(legacy.Channels, &permissions); err == nil {
+		user.Channels = make(ChannelGrants, len(permissions))
+		for name, permission := range permissions {
+			user.Channels[name] = ChannelGrant{Permission: permission}
+		}
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(legacy.Channels, &names); err != nil {
+		return err
+	}
+	user.Channels = make(ChannelGrants, len(names))
+	for _, name := range names {
+		user.Channels[name] = ChannelGrant{Permission: PermissionReadWrite}
+	}
+	return nil
 }
 
 //////// USER OBJECT API:
 
 // Creates a new User object.
 func NewUser(username string, password string, channels []string) (*User, error) {
-	user := &User{Name: username, Channels: channels}
-	user.SetPassword(password)
+	grants := make(ChannelGrants, len(channels))
+	for _, channel := range channels {
+		grants[channel] = ChannelGrant{Permission: PermissionReadWrite}
+	}
+	user := &User{Name: username, Role: RoleUser, Channels: grants}
+	if err := user.SetPassword(password); err != nil {
+		return nil, err
+	}
 	if err := user.Validate(); err != nil {
 		return nil, err
 	}
@@ -108,22 +136,30 @@ func (user *User) Validate() error {
 // Returns true if the given password is correct for this user.
 func (user *User) Authenticate(password string) bool {
 	if user.PasswordHash == nil {
-		if password != "" {
-			return false
-		}
-	} else if !user.PasswordHash.EqualToPassword(password) {
-		return false
+		return password == ""
 	}
-	return true
+	hasher := passwordHasherForAlgo(user.PasswordHash.Algo)
+	return hasher != nil && hasher.Verify(user.PasswordHash, password)
+}
+
+// Returns true if the user's password hash was created with an algorithm older than
+// DefaultPasswordHasher, and should be upgraded the next time the password is known.
+func (user *User) needsPasswordUpgrade() bool {
+	return user.PasswordHash != nil && user.PasswordHash.Algo != DefaultPasswordHasher.Algorithm()
 }
 
-// Changes a user's password to the given string.
-func (user *User) SetPassword(password string) {
+// Changes a user's password to the given string, hashing it with DefaultPasswordHasher.
+func (user *User) SetPassword(password string) error {
 	if password == "" {
 		user.PasswordHash = nil
-	} else {
-		user.PasswordHash = passwordhash.New(password)
+		return nil
+	}
+	hash, err := DefaultPasswordHasher.Hash(password)
+	if err != nil {
+		return err
 	}
+	user.PasswordHash = hash
+	return nil
 }
 
 func (user *User) unauthError(message string) error {
@@ -136,8 +172,19 @@ func (user *User) unauthError(message string) error {
 // Returns true if the User is allowed to access the channel.
 // A nil User means access control is disabled, so the function will return true.
 func (user *User) CanSeeChannel(channel string) bool {
-	return user == nil || channel == "*" || stringListContains(user.Channels, channel) ||
-		stringListContains(user.Channels, "*")
+	if user == nil || channel == "*" || user.Role == RoleAdmin {
+		return true
+	}
+	return user.InheritedChannels().effectiveGrant(channel).canRead()
+}
+
+// Returns true if the User is allowed to write to the channel.
+// A nil User means access control is disabled, so the function will return true.
+func (user *User) CanWriteChannel(channel string) bool {
+	if user == nil || channel == "*" || user.Role == RoleAdmin {
+		return true
+	}
+	return user.InheritedChannels().effectiveGrant(channel).canWrite()
 }
 
 // Returns true if the User is allowed to access all of the given channels.
@@ -164,7 +211,21 @@ func (user *User) CanSeeAnyChannels(channels []string) bool {
 		}
 	}
 	// If user has wildcard access, allow it anyway
-	return stringListContains(user.Channels, "*")
+	return user == nil || (len(channels) == 0 && user.InheritedChannels()["*"].canRead())
+}
+
+// Returns an HTTP 403 error if the User is not allowed to write to any of the given channels.
+// A nil User means access control is disabled, so the function will return nil.
+func (user *User) AuthorizeWriteChannels(channels []string) error {
+	if user == nil {
+		return nil
+	}
+	for _, channel := range channels {
+		if user.CanWriteChannel(channel) {
+			return nil
+		}
+	}
+	return user.unauthError("You are not allowed to write to this channel")
 }
 
 // Returns an HTTP 403 error if the User is not allowed to access all the given channels.
@@ -199,98 +260,18 @@ func (user *User) AuthorizeAnyChannels(channels []string) error {
 func (user *User) AuthorizeAnyDocChannels(channels ChannelMap) error {
 	if user == nil {
 		return nil
-	} else if user.Channels != nil {
-		for _, channel := range user.Channels {
-			if channel == "*" {
-				return nil
-			}
-			value, exists := channels[channel]
-			if exists && value == nil {
-				return nil // yup, it's in this channel
-			}
-		}
 	}
-	return user.unauthError("You are not allowed to see this")
-}
-
-func stringListContains(list []string, str string) bool {
-	if list != nil {
-		for _, item := range list {
-			if item == str {
-				return true
-			}
+	inherited := user.InheritedChannels()
+	for channel, removed := range channels {
+		if removed != nil {
+			continue // the doc isn't currently in this channel
 		}
-	}
-	return false
-}
-
-//////// COOKIE-BASED AUTH:
-
-/*
-// A user login session (used with cookie-based auth.)
-type Session struct {
-	id string
-	user User
-	expiration time.Time
-}
-
-const kCookieName = "BaseCouchSession"
-
-func (s *Authenticator) authenticateCookie(cookie *http.Cookie) User {
-	if cookie == nil {
-		return nil
-	}
-
-	s.lock.Lock()
-	defer s.lock.Unlock()
-
-	session, found := s.sessions[cookie.Value]
-	if !found {
-		return nil
-	}
-	if session.expiration.Before(time.Now()) {
-		delete(s.sessions, cookie.Value)
-		return nil
-	}
-	return session.user
-}
-
-func (s *Authenticator) createSession(channels []string, ttl time.Duration, r http.ResponseWriter) Session{
-	s.lock.Lock()
-	defer s.lock.Unlock()
-
-	// Create a random unused session ID:
-	var sessionID string
-	for {
-		randomBytes := make([]byte, 20)
-		n, err := io.ReadFull(rand.Reader, randomBytes)
-		if n < len(randomBytes) || err != nil {
-			panic("RNG failed, can't create session")
-		}
-		sessionID = fmt.Sprintf("%x", randomBytes)
-		if _, found := s.sessions[sessionID]; !found {
-			break
+		if inherited.effectiveGrant(channel).canRead() {
+			return nil
 		}
 	}
-
-	expiration := time.Now().Add(ttl)
-	session := &Session{
-		id: sessionID
-		channels: channels,
-		expiration: expiration,
-	}
-	s.sessions[sessionID] = session
-	return session
+	return user.unauthError("You are not allowed to see this")
 }
 
-func (s *Authenticator) makeSessionCookie(s *Session) *http.Cookie {
-	if session == nil {
-		return nil
-	}
-	return &http.Cookie{
-		Name: kCookieName,
-		Value: s.id,
-		Expires: s.expiration,
-	}
-}
-*/
+//////// COOKIE-BASED AUTH:
+// See session.go for Session management and the /_session HTTP handlers.
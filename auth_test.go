@@ -0,0 +1,74 @@
+//  Copyright (c) 2012 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package channelsync
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/dchest/passwordhash"
+)
+
+// A user document in the pre-PasswordHasher format, where "passwordhash" is a raw
+// dchest/passwordhash value rather than the tagged {"algo":...,"hash":...} envelope.
+func TestUserUnmarshalLegacyPasswordHash(t *testing.T) {
+	legacyHash, err := json.Marshal(passwordhash.New("letmein"))
+	if err != nil {
+		t.Fatalf("failed to marshal legacy hash: %v", err)
+	}
+	doc := []byte(`{"name":"alice","passwordhash":` + string(legacyHash) + `,"channels":["foo"]}`)
+
+	var user User
+	if err := json.Unmarshal(doc, &user); err != nil {
+		t.Fatalf("UnmarshalJSON failed on legacy doc: %v", err)
+	}
+	if user.PasswordHash == nil || user.PasswordHash.Algo != algoLegacy {
+		t.Fatalf("expected legacy-tagged PasswordHash, got %+v", user.PasswordHash)
+	}
+	if !user.Authenticate("letmein") {
+		t.Error("expected legacy password hash to authenticate with the correct password")
+	}
+	if user.Authenticate("wrong password") {
+		t.Error("expected legacy password hash to reject the wrong password")
+	}
+}
+
+// A user document with no stored password (guest-like) must unmarshal with no PasswordHash.
+func TestUserUnmarshalNoPasswordHash(t *testing.T) {
+	var user User
+	if err := json.Unmarshal([]byte(`{"name":"bob","channels":["foo"]}`), &user); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if user.PasswordHash != nil {
+		t.Fatalf("expected nil PasswordHash, got %+v", user.PasswordHash)
+	}
+}
+
+// A user saved in the current format must still authenticate, and stay tagged bcrypt.
+func TestUserUnmarshalCurrentPasswordHash(t *testing.T) {
+	user := &User{Name: "carol"}
+	if err := user.SetPassword("hunter2"); err != nil {
+		t.Fatalf("SetPassword failed: %v", err)
+	}
+	doc, err := json.Marshal(user)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var roundTripped User
+	if err := json.Unmarshal(doc, &roundTripped); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if roundTripped.PasswordHash == nil || roundTripped.PasswordHash.Algo != algoBcrypt {
+		t.Fatalf("expected bcrypt-tagged PasswordHash, got %+v", roundTripped.PasswordHash)
+	}
+	if !roundTripped.Authenticate("hunter2") {
+		t.Error("expected current-format password hash to authenticate")
+	}
+}
@@ -0,0 +1,66 @@
+//  Copyright (c) 2012 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package channelsync
+
+import (
+	"net/http"
+	"time"
+)
+
+// Manages user accounts and login sessions for a database. CouchbaseAuthenticator is the
+// original, bucket-backed implementation; SQLiteAuthenticator stores the same data in a
+// local SQLite file for deployments that don't want credentials in the data bucket.
+type Authenticator interface {
+	// Looks up the information for a user. Returns a non-nil guest User, not an error,
+	// if username is "". Returns (nil, nil), not an error, if username is unknown.
+	GetUser(username string) (*User, error)
+
+	// Saves the information for a user, hashing its Password field if one is set.
+	SaveUser(user *User) error
+
+	// Deletes a user.
+	DeleteUser(username string) error
+
+	// Authenticates a user given the username and password, returning nil on failure.
+	AuthenticateUser(username string, password string) *User
+
+	// Creates a new login Session for the given user.
+	CreateSession(username string, ttl time.Duration) (*Session, error)
+
+	// Looks up a Session by ID. Returns nil (with no error) if it doesn't exist or expired.
+	GetSession(id string) (*Session, error)
+
+	// Deletes a Session, logging its user out.
+	DeleteSession(id string) error
+
+	// Looks up the User associated with a BaseCouchSession cookie.
+	AuthenticateCookie(cookie *http.Cookie) (*User, error)
+
+	// Looks up a Role by name. Returns nil (with no error) if it doesn't exist.
+	GetRole(name string) (*Role, error)
+
+	// Saves a Role.
+	SaveRole(role *Role) error
+
+	// Deletes a Role.
+	DeleteRole(name string) error
+}
+
+// upgradePasswordIfNeeded re-hashes and saves a user's password with DefaultPasswordHasher
+// after a successful login with an older hash algorithm. Best-effort: a failure to save
+// doesn't fail the login that triggered it.
+func upgradePasswordIfNeeded(auth Authenticator, user *User, password string) {
+	if !user.needsPasswordUpgrade() {
+		return
+	}
+	if err := user.SetPassword(password); err == nil {
+		auth.SaveUser(user)
+	}
+}
@@ -0,0 +1,222 @@
+//  Copyright (c) 2012 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package channelsync
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS users (
+	name TEXT PRIMARY KEY,
+	doc  TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS sessions (
+	id  TEXT PRIMARY KEY,
+	doc TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS roles (
+	name TEXT PRIMARY KEY,
+	doc  TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS sequence_counter (
+	value INTEGER NOT NULL
+);
+INSERT INTO sequence_counter (value)
+	SELECT 0 WHERE NOT EXISTS (SELECT 1 FROM sequence_counter);`
+
+/*
+* Authenticator that stores user and session info in a local SQLite database, for
+deployments that don't want credentials stored in the Couchbase data bucket.
+*/
+type SQLiteAuthenticator struct {
+	db *sql.DB
+}
+
+// Opens (creating if necessary) a SQLite-backed Authenticator at the given file path.
+func NewSQLiteAuthenticator(path string) (*SQLiteAuthenticator, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteAuthenticator{db: db}, nil
+}
+
+// Looks up the information for a user.
+// If the username is "" it will return the default (guest) User object, not nil.
+// Returns (nil, nil), not an error, if username is unknown.
+func (auth *SQLiteAuthenticator) GetUser(username string) (*User, error) {
+	var doc string
+	err := auth.db.QueryRow(`SELECT doc FROM users WHERE name = ?`, username).Scan(&doc)
+	if err == sql.ErrNoRows {
+		if username == "" {
+			guest := &User{Name: username, Role: RoleAnonymous, Channels: ChannelGrants{"*": ChannelGrant{Permission: PermissionReadWrite}}}
+			guest.SetAuthenticator(auth)
+			return guest, nil
+		}
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var user *User
+	if err := json.Unmarshal([]byte(doc), &user); err != nil {
+		return nil, err
+	}
+	user.SetAuthenticator(auth)
+	return user, nil
+}
+
+// Atomically allocates the next sequence number, used to timestamp newly granted channels.
+func (auth *SQLiteAuthenticator) nextSequence() (uint64, error) {
+	tx, err := auth.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE sequence_counter SET value = value + 1`); err != nil {
+		return 0, err
+	}
+	var seq uint64
+	if err := tx.QueryRow(`SELECT value FROM sequence_counter`).Scan(&seq); err != nil {
+		return 0, err
+	}
+	return seq, tx.Commit()
+}
+
+func (auth *SQLiteAuthenticator) SaveUser(user *User) error {
+	if user.Password != nil {
+		if err := user.SetPassword(*user.Password); err != nil {
+			return err
+		}
+		user.Password = nil
+	}
+	if err := user.Validate(); err != nil {
+		return err
+	}
+	old, _ := auth.GetUser(user.Name)
+	if err := stampChannelSequences(user, old, auth.nextSequence); err != nil {
+		return err
+	}
+	doc, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	_, err = auth.db.Exec(`INSERT INTO users (name, doc) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET doc = excluded.doc`, user.Name, string(doc))
+	return err
+}
+
+func (auth *SQLiteAuthenticator) DeleteUser(username string) error {
+	_, err := auth.db.Exec(`DELETE FROM users WHERE name = ?`, username)
+	return err
+}
+
+func (auth *SQLiteAuthenticator) AuthenticateUser(username string, password string) *User {
+	user, _ := auth.GetUser(username)
+	if user == nil || !user.Authenticate(password) {
+		return nil
+	}
+	upgradePasswordIfNeeded(auth, user, password)
+	return user
+}
+
+func (auth *SQLiteAuthenticator) GetRole(name string) (*Role, error) {
+	var doc string
+	err := auth.db.QueryRow(`SELECT doc FROM roles WHERE name = ?`, name).Scan(&doc)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var role *Role
+	if err := json.Unmarshal([]byte(doc), &role); err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (auth *SQLiteAuthenticator) SaveRole(role *Role) error {
+	doc, err := json.Marshal(role)
+	if err != nil {
+		return err
+	}
+	_, err = auth.db.Exec(`INSERT INTO roles (name, doc) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET doc = excluded.doc`, role.Name, string(doc))
+	return err
+}
+
+func (auth *SQLiteAuthenticator) DeleteRole(name string) error {
+	_, err := auth.db.Exec(`DELETE FROM roles WHERE name = ?`, name)
+	return err
+}
+
+func (auth *SQLiteAuthenticator) CreateSession(username string, ttl time.Duration) (*Session, error) {
+	sessionID, err := createSessionID()
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{
+		ID:         sessionID,
+		Username:   username,
+		Expiration: time.Now().Add(ttl),
+	}
+	doc, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := auth.db.Exec(`INSERT INTO sessions (id, doc) VALUES (?, ?)`, session.ID, string(doc)); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (auth *SQLiteAuthenticator) GetSession(id string) (*Session, error) {
+	var doc string
+	err := auth.db.QueryRow(`SELECT doc FROM sessions WHERE id = ?`, id).Scan(&doc)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var session *Session
+	if err := json.Unmarshal([]byte(doc), &session); err != nil {
+		return nil, err
+	}
+	if session.Expiration.Before(time.Now()) {
+		auth.DeleteSession(id)
+		return nil, nil
+	}
+	return session, nil
+}
+
+func (auth *SQLiteAuthenticator) DeleteSession(id string) error {
+	_, err := auth.db.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+	return err
+}
+
+func (auth *SQLiteAuthenticator) AuthenticateCookie(cookie *http.Cookie) (*User, error) {
+	if cookie == nil {
+		return nil, nil
+	}
+	session, err := auth.GetSession(cookie.Value)
+	if err != nil || session == nil {
+		return nil, err
+	}
+	return auth.GetUser(session.Username)
+}
@@ -0,0 +1,117 @@
+//  Copyright (c) 2012 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package channelsync
+
+import (
+	"github.com/couchbaselabs/go-couchbase"
+)
+
+/** Authenticator that stores user and session info in a Couchbase bucket. */
+type CouchbaseAuthenticator struct {
+	bucket *couchbase.Bucket
+}
+
+// Creates a new Authenticator that stores user info in the given Bucket.
+func NewAuthenticator(bucket *couchbase.Bucket) *CouchbaseAuthenticator {
+	return &CouchbaseAuthenticator{
+		bucket: bucket,
+	}
+}
+
+func docIDForUser(username string) string {
+	return "user:" + username
+}
+
+const sequenceCounterDocID = "sequence"
+
+// Atomically allocates the next sequence number, used to timestamp newly granted channels.
+func (auth *CouchbaseAuthenticator) nextSequence() (uint64, error) {
+	return auth.bucket.Incr(sequenceCounterDocID, 1, 1, 0)
+}
+
+// Returns true if err indicates that bucket.Get found no document for the given key.
+func isDocNotFoundError(err error) bool {
+	return err != nil && err.Error() == "Not found"
+}
+
+// Looks up the information for a user.
+// If the username is "" it will return the default (guest) User object, not nil.
+// By default the guest User has access to everything, i.e. Admin Party! This can
+// be changed by altering its list of channels and saving the changes via SaveUser.
+// Returns (nil, nil), not an error, if username is unknown.
+func (auth *CouchbaseAuthenticator) GetUser(username string) (*User, error) {
+	var user *User
+	err := auth.bucket.Get(docIDForUser(username), &user)
+	if err != nil && isDocNotFoundError(err) {
+		user, err = nil, nil
+	}
+	if user == nil && err == nil && username == "" {
+		user = &User{Name: username, Role: RoleAnonymous, Channels: ChannelGrants{"*": ChannelGrant{Permission: PermissionReadWrite}}}
+	}
+	if user != nil {
+		user.SetAuthenticator(auth)
+	}
+	return user, err
+}
+
+// Saves the information for a user.
+func (auth *CouchbaseAuthenticator) SaveUser(user *User) error {
+	if user.Password != nil {
+		if err := user.SetPassword(*user.Password); err != nil {
+			return err
+		}
+		user.Password = nil
+	}
+	if err := user.Validate(); err != nil {
+		return err
+	}
+	var old *User
+	auth.bucket.Get(docIDForUser(user.Name), &old)
+	if err := stampChannelSequences(user, old, auth.nextSequence); err != nil {
+		return err
+	}
+	return auth.bucket.Set(docIDForUser(user.Name), 0, user)
+}
+
+// Deletes a user.
+func (auth *CouchbaseAuthenticator) DeleteUser(username string) error {
+	return auth.bucket.Delete(docIDForUser(username))
+}
+
+// Authenticates a user given the username and password.
+// If the username and password are both "", it will return a default empty User object, not nil.
+func (auth *CouchbaseAuthenticator) AuthenticateUser(username string, password string) *User {
+	user, _ := auth.GetUser(username)
+	if user == nil || !user.Authenticate(password) {
+		return nil
+	}
+	upgradePasswordIfNeeded(auth, user, password)
+	return user
+}
+
+// Looks up a Role by name.
+func (auth *CouchbaseAuthenticator) GetRole(name string) (*Role, error) {
+	var role *Role
+	err := auth.bucket.Get(docIDForRole(name), &role)
+	if isDocNotFoundError(err) {
+		return nil, nil
+	}
+	return role, err
+}
+
+// Saves a Role.
+func (auth *CouchbaseAuthenticator) SaveRole(role *Role) error {
+	return auth.bucket.Set(docIDForRole(role.Name), 0, role)
+}
+
+// Deletes a Role.
+func (auth *CouchbaseAuthenticator) DeleteRole(name string) error {
+	return auth.bucket.Delete(docIDForRole(name))
+}
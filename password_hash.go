@@ -0,0 +1,102 @@
+//  Copyright (c) 2012 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package channelsync
+
+import (
+	"encoding/json"
+
+	"github.com/dchest/passwordhash"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Identifies the algorithm used to produce a PasswordHash, so that more than one can coexist.
+const (
+	algoBcrypt = "bcrypt"
+	algoLegacy = "legacy" // a dchest/passwordhash hash, kept only for verifying old accounts.
+)
+
+// An opaque, tagged password hash, stored in the User document as `{"algo":..., "hash":...}`.
+// This lets the set of supported hashing algorithms change over time without a schema migration.
+type PasswordHash struct {
+	Algo string          `json:"algo"`
+	Hash json.RawMessage `json:"hash"`
+}
+
+// Computes a PasswordHash and verifies it against a plaintext password.
+type PasswordHasher interface {
+	Algorithm() string
+	Hash(password string) (*PasswordHash, error)
+	Verify(hash *PasswordHash, password string) bool
+}
+
+// The PasswordHasher used for new and upgraded passwords. Defaults to bcrypt at cost 10.
+var DefaultPasswordHasher PasswordHasher = BcryptHasher{Cost: bcrypt.DefaultCost}
+
+// Returns the PasswordHasher that can verify a hash tagged with the given algorithm,
+// or nil if the algorithm is unrecognized.
+func passwordHasherForAlgo(algo string) PasswordHasher {
+	switch algo {
+	case algoBcrypt:
+		return DefaultPasswordHasher
+	case algoLegacy:
+		return legacyHasher{}
+	default:
+		return nil
+	}
+}
+
+// A PasswordHasher backed by bcrypt, with a configurable cost factor.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Algorithm() string { return algoBcrypt }
+
+func (h BcryptHasher) Hash(password string) (*PasswordHash, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(string(hash))
+	if err != nil {
+		return nil, err
+	}
+	return &PasswordHash{Algo: algoBcrypt, Hash: data}, nil
+}
+
+func (h BcryptHasher) Verify(hash *PasswordHash, password string) bool {
+	var encoded string
+	if err := json.Unmarshal(hash.Hash, &encoded); err != nil {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+}
+
+// legacyHasher only verifies hashes created by the old dchest/passwordhash package; it's never
+// used to create new hashes, since SetPassword always hashes with DefaultPasswordHasher.
+type legacyHasher struct{}
+
+func (legacyHasher) Algorithm() string { return algoLegacy }
+
+func (legacyHasher) Hash(password string) (*PasswordHash, error) {
+	data, err := json.Marshal(passwordhash.New(password))
+	if err != nil {
+		return nil, err
+	}
+	return &PasswordHash{Algo: algoLegacy, Hash: data}, nil
+}
+
+func (legacyHasher) Verify(hash *PasswordHash, password string) bool {
+	var legacy passwordhash.PasswordHash
+	if err := json.Unmarshal(hash.Hash, &legacy); err != nil {
+		return false
+	}
+	return legacy.EqualToPassword(password)
+}
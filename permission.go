@@ -0,0 +1,37 @@
+//  Copyright (c) 2012 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package channelsync
+
+// The access a user (or role) has been granted to a single channel.
+type Permission string
+
+const (
+	PermissionReadWrite Permission = "read-write" // May read and write documents in the channel.
+	PermissionReadOnly  Permission = "read-only"  // May read documents in the channel but not write them.
+	PermissionWriteOnly Permission = "write-only" // May write documents in the channel but not read them.
+	PermissionDeny      Permission = "deny"       // May neither read nor write; overrides any inherited grant.
+)
+
+func (p Permission) canRead() bool {
+	return p == PermissionReadWrite || p == PermissionReadOnly
+}
+
+func (p Permission) canWrite() bool {
+	return p == PermissionReadWrite || p == PermissionWriteOnly
+}
+
+// A user's top-level role, independent of per-channel grants.
+type UserRole string
+
+const (
+	RoleAdmin     UserRole = "admin"     // Bypasses all channel permission checks.
+	RoleUser      UserRole = "user"      // Subject to its per-channel grants.
+	RoleAnonymous UserRole = "anonymous" // The default, unauthenticated guest role.
+)
@@ -0,0 +1,52 @@
+//  Copyright (c) 2012 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package channelsync
+
+import "testing"
+
+// A user with a "*" read-write grant and an explicit deny on one channel must still be
+// denied on that channel: the deny is more specific than the wildcard and must win.
+func TestChannelDenyOverridesWildcard(t *testing.T) {
+	user := &User{
+		Name: "dave",
+		Channels: ChannelGrants{
+			"*":      {Permission: PermissionReadWrite},
+			"secret": {Permission: PermissionDeny},
+		},
+	}
+	if user.CanSeeChannel("secret") {
+		t.Error("explicit deny on \"secret\" should override the \"*\" wildcard for CanSeeChannel")
+	}
+	if user.CanWriteChannel("secret") {
+		t.Error("explicit deny on \"secret\" should override the \"*\" wildcard for CanWriteChannel")
+	}
+	if !user.CanSeeChannel("other") {
+		t.Error("the \"*\" wildcard should still grant read access to channels with no specific entry")
+	}
+	if !user.CanWriteChannel("other") {
+		t.Error("the \"*\" wildcard should still grant write access to channels with no specific entry")
+	}
+}
+
+func TestAuthorizeAnyDocChannelsRespectsDeny(t *testing.T) {
+	user := &User{
+		Name: "dave",
+		Channels: ChannelGrants{
+			"*":      {Permission: PermissionReadWrite},
+			"secret": {Permission: PermissionDeny},
+		},
+	}
+	if err := user.AuthorizeAnyDocChannels(ChannelMap{"secret": nil}); err == nil {
+		t.Error("expected AuthorizeAnyDocChannels to deny a doc only in the denied channel")
+	}
+	if err := user.AuthorizeAnyDocChannels(ChannelMap{"secret": nil, "other": nil}); err != nil {
+		t.Errorf("expected AuthorizeAnyDocChannels to allow via the wildcard-covered channel, got %v", err)
+	}
+}
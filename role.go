@@ -0,0 +1,52 @@
+//  Copyright (c) 2012 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package channelsync
+
+// A named set of channels that can be granted to many users at once via User.RoleNames,
+// so an admin doesn't have to edit every user document when a shared channel set changes.
+type Role struct {
+	Name     string   `json:"name,omitempty"`
+	Channels []string `json:"channels"`
+}
+
+func docIDForRole(name string) string {
+	return "role:" + name
+}
+
+// Returns the union of this User's own channel grants and the channels granted by every
+// role named in RoleNames, each as read-write. The result is cached on the User so that
+// repeated authorization checks during a single request don't re-fetch roles from storage.
+func (user *User) InheritedChannels() ChannelGrants {
+	if user == nil {
+		return nil
+	}
+	if user.inheritedChannels != nil {
+		return user.inheritedChannels
+	}
+	merged := make(ChannelGrants, len(user.Channels))
+	for channel, permission := range user.Channels {
+		merged[channel] = permission
+	}
+	if user.auth != nil {
+		for _, roleName := range user.RoleNames {
+			role, err := user.auth.GetRole(roleName)
+			if err != nil || role == nil {
+				continue
+			}
+			for _, channel := range role.Channels {
+				if _, exists := merged[channel]; !exists {
+					merged[channel] = ChannelGrant{Permission: PermissionReadWrite}
+				}
+			}
+		}
+	}
+	user.inheritedChannels = merged
+	return merged
+}
@@ -0,0 +1,90 @@
+//  Copyright (c) 2012 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package channelsync
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// A stub Authenticator that only serves GetRole from an in-memory map, for exercising
+// InheritedChannels without a real storage backend.
+type fakeRoleAuthenticator struct {
+	roles map[string]*Role
+}
+
+func (f *fakeRoleAuthenticator) GetUser(string) (*User, error)         { return nil, nil }
+func (f *fakeRoleAuthenticator) SaveUser(*User) error                  { return nil }
+func (f *fakeRoleAuthenticator) DeleteUser(string) error               { return nil }
+func (f *fakeRoleAuthenticator) AuthenticateUser(string, string) *User { return nil }
+func (f *fakeRoleAuthenticator) CreateSession(string, time.Duration) (*Session, error) {
+	return nil, nil
+}
+func (f *fakeRoleAuthenticator) GetSession(string) (*Session, error) { return nil, nil }
+func (f *fakeRoleAuthenticator) DeleteSession(string) error          { return nil }
+func (f *fakeRoleAuthenticator) AuthenticateCookie(*http.Cookie) (*User, error) {
+	return nil, nil
+}
+func (f *fakeRoleAuthenticator) GetRole(name string) (*Role, error) { return f.roles[name], nil }
+func (f *fakeRoleAuthenticator) SaveRole(*Role) error               { return nil }
+func (f *fakeRoleAuthenticator) DeleteRole(string) error            { return nil }
+
+// InheritedChannels must union a user's own channels with every named role's channels.
+func TestInheritedChannelsUnionsRoleChannels(t *testing.T) {
+	auth := &fakeRoleAuthenticator{roles: map[string]*Role{
+		"editor": {Name: "editor", Channels: []string{"drafts"}},
+	}}
+	user := &User{Channels: ChannelGrants{"mine": {Permission: PermissionReadWrite}}, RoleNames: []string{"editor"}}
+	user.SetAuthenticator(auth)
+
+	inherited := user.InheritedChannels()
+	if !inherited["mine"].canWrite() {
+		t.Error("expected the user's own channel grant to be present")
+	}
+	if !inherited["drafts"].canWrite() {
+		t.Error("expected the role's channel to be inherited as read-write")
+	}
+}
+
+// A role granting a channel must not override an explicit grant (including deny) the user
+// already has on that exact channel.
+func TestInheritedChannelsRoleDoesNotOverrideExplicitUserGrant(t *testing.T) {
+	auth := &fakeRoleAuthenticator{roles: map[string]*Role{
+		"editor": {Name: "editor", Channels: []string{"drafts"}},
+	}}
+	user := &User{Channels: ChannelGrants{"drafts": {Permission: PermissionDeny}}, RoleNames: []string{"editor"}}
+	user.SetAuthenticator(auth)
+
+	if inherited := user.InheritedChannels(); inherited["drafts"].canRead() {
+		t.Error("expected the user's explicit deny on \"drafts\" to survive role inheritance")
+	}
+}
+
+// InheritedChannels caches its result on the User, so a role change made after the first
+// call isn't picked up until a fresh User is loaded.
+func TestInheritedChannelsCachesPerUser(t *testing.T) {
+	auth := &fakeRoleAuthenticator{roles: map[string]*Role{
+		"editor": {Name: "editor", Channels: []string{"drafts"}},
+	}}
+	user := &User{RoleNames: []string{"editor"}}
+	user.SetAuthenticator(auth)
+
+	first := user.InheritedChannels()
+	if _, hasDrafts := first["drafts"]; !hasDrafts {
+		t.Fatal("expected \"drafts\" to be inherited from the role on the first call")
+	}
+
+	auth.roles["editor"].Channels = append(auth.roles["editor"].Channels, "published")
+	second := user.InheritedChannels()
+	if _, hasPublished := second["published"]; hasPublished {
+		t.Error("expected the cached InheritedChannels result to ignore a role change after the first call")
+	}
+}
@@ -0,0 +1,154 @@
+//  Copyright (c) 2012 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package channelsync
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// The name of the cookie BaseCouch sets to identify a login session.
+const kCookieName = "BaseCouchSession"
+
+// A login session, created by a successful call to POST /_session and persisted by the Authenticator.
+type Session struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	Expiration time.Time `json:"expiration"`
+}
+
+func docIDForSession(sessionID string) string {
+	return "session:" + sessionID
+}
+
+// Creates a new Session for the given user, persists it, and returns it.
+func (auth *CouchbaseAuthenticator) CreateSession(username string, ttl time.Duration) (*Session, error) {
+	sessionID, err := createSessionID()
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{
+		ID:         sessionID,
+		Username:   username,
+		Expiration: time.Now().Add(ttl),
+	}
+	if err := auth.bucket.Set(docIDForSession(session.ID), int(ttl/time.Second), session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Looks up a Session by its ID. Returns nil if the session doesn't exist or has expired.
+func (auth *CouchbaseAuthenticator) GetSession(id string) (*Session, error) {
+	var session *Session
+	if err := auth.bucket.Get(docIDForSession(id), &session); err != nil {
+		if isDocNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if session != nil && session.Expiration.Before(time.Now()) {
+		auth.DeleteSession(id)
+		return nil, nil
+	}
+	return session, nil
+}
+
+// Deletes a Session, effectively logging its user out.
+func (auth *CouchbaseAuthenticator) DeleteSession(id string) error {
+	return auth.bucket.Delete(docIDForSession(id))
+}
+
+// Looks up the User associated with a BaseCouchSession cookie.
+// Returns nil (with no error) if the cookie is missing, invalid, or its session has expired.
+func (auth *CouchbaseAuthenticator) AuthenticateCookie(cookie *http.Cookie) (*User, error) {
+	if cookie == nil {
+		return nil, nil
+	}
+	session, err := auth.GetSession(cookie.Value)
+	if err != nil || session == nil {
+		return nil, err
+	}
+	return auth.GetUser(session.Username)
+}
+
+// Generates a random 20-byte session ID, hex-encoded.
+func createSessionID() (string, error) {
+	randomBytes := make([]byte, 20)
+	if n, err := io.ReadFull(rand.Reader, randomBytes); n < len(randomBytes) || err != nil {
+		return "", fmt.Errorf("RNG failed, can't create session: %v", err)
+	}
+	return fmt.Sprintf("%x", randomBytes), nil
+}
+
+func makeSessionCookie(session *Session, secure bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     kCookieName,
+		Value:    session.ID,
+		Path:     "/",
+		Expires:  session.Expiration,
+		HttpOnly: true,
+		Secure:   secure,
+	}
+}
+
+//////// HTTP HANDLERS:
+
+type sessionRequestBody struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// Handles POST /_session: authenticates the given username/password and, on success,
+// creates a session and sets it as a cookie on the response.
+func HandleCreateSession(auth Authenticator, w http.ResponseWriter, r *http.Request) error {
+	var body sessionRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return &HTTPError{http.StatusBadRequest, "Invalid JSON body"}
+	}
+	user := auth.AuthenticateUser(body.Name, body.Password)
+	if user == nil {
+		return &HTTPError{http.StatusUnauthorized, "Invalid login"}
+	}
+	session, err := auth.CreateSession(user.Name, 24*time.Hour)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, makeSessionCookie(session, r.TLS != nil))
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":   true,
+		"name": user.Name,
+	})
+}
+
+// Handles DELETE /_session: logs out the session named by the BaseCouchSession cookie.
+func HandleDeleteSession(auth Authenticator, w http.ResponseWriter, r *http.Request) error {
+	cookie, err := r.Cookie(kCookieName)
+	if err == nil {
+		if err := auth.DeleteSession(cookie.Value); err != nil {
+			return err
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     kCookieName,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]interface{}{"ok": true})
+}
@@ -0,0 +1,90 @@
+//  Copyright (c) 2012 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package channelsync
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestAuthenticator(t *testing.T) *SQLiteAuthenticator {
+	auth, err := NewSQLiteAuthenticator(":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory SQLiteAuthenticator: %v", err)
+	}
+	return auth
+}
+
+// A freshly created session must be retrievable by ID and resolve to its user via a cookie.
+func TestCreateAndAuthenticateCookie(t *testing.T) {
+	auth := newTestAuthenticator(t)
+	user, err := NewUser("alice", "hunter2", nil)
+	if err != nil {
+		t.Fatalf("NewUser failed: %v", err)
+	}
+	if err := auth.SaveUser(user); err != nil {
+		t.Fatalf("SaveUser failed: %v", err)
+	}
+	session, err := auth.CreateSession("alice", time.Hour)
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+
+	found, err := auth.AuthenticateCookie(&http.Cookie{Name: kCookieName, Value: session.ID})
+	if err != nil {
+		t.Fatalf("AuthenticateCookie failed: %v", err)
+	}
+	if found == nil || found.Name != "alice" {
+		t.Fatalf("expected to authenticate as alice, got %+v", found)
+	}
+}
+
+// An expired session must be rejected by both GetSession and AuthenticateCookie.
+func TestExpiredSessionIsRejected(t *testing.T) {
+	auth := newTestAuthenticator(t)
+	session, err := auth.CreateSession("alice", -time.Hour) // already expired
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+
+	if found, err := auth.GetSession(session.ID); err != nil || found != nil {
+		t.Errorf("expected GetSession to reject an expired session, got (%+v, %v)", found, err)
+	}
+	if user, err := auth.AuthenticateCookie(&http.Cookie{Name: kCookieName, Value: session.ID}); err != nil || user != nil {
+		t.Errorf("expected AuthenticateCookie to reject an expired session, got (%+v, %v)", user, err)
+	}
+}
+
+// A deleted session must no longer authenticate.
+func TestDeleteSessionLogsOut(t *testing.T) {
+	auth := newTestAuthenticator(t)
+	session, err := auth.CreateSession("alice", time.Hour)
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	if err := auth.DeleteSession(session.ID); err != nil {
+		t.Fatalf("DeleteSession failed: %v", err)
+	}
+	if user, err := auth.AuthenticateCookie(&http.Cookie{Name: kCookieName, Value: session.ID}); err != nil || user != nil {
+		t.Errorf("expected AuthenticateCookie to reject a deleted session, got (%+v, %v)", user, err)
+	}
+}
+
+// A nil or unknown-session cookie must resolve to no user and no error.
+func TestAuthenticateCookieWithNoCookie(t *testing.T) {
+	auth := newTestAuthenticator(t)
+	if user, err := auth.AuthenticateCookie(nil); err != nil || user != nil {
+		t.Errorf("expected (nil, nil) for a nil cookie, got (%+v, %v)", user, err)
+	}
+	if user, err := auth.AuthenticateCookie(&http.Cookie{Name: kCookieName, Value: "bogus"}); err != nil || user != nil {
+		t.Errorf("expected (nil, nil) for an unknown session ID, got (%+v, %v)", user, err)
+	}
+}
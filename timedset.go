@@ -0,0 +1,64 @@
+//  Copyright (c) 2012 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package channelsync
+
+// A set of channel names, each timestamped with the sequence number at which it was granted.
+// Lets a replicator be told "you just gained access to channels X, Y at sequence N" so it
+// knows which channels need to be backfilled, instead of resending the user's whole history.
+type TimedSet map[string]uint64
+
+// The access a user (or role) has been granted to a single channel: what they may do with
+// it, and the sequence at which that grant took effect.
+type ChannelGrant struct {
+	Permission Permission `json:"permission"`
+	Sequence   uint64     `json:"sequence"`
+}
+
+func (grant ChannelGrant) canRead() bool  { return grant.Permission.canRead() }
+func (grant ChannelGrant) canWrite() bool { return grant.Permission.canWrite() }
+
+// Returns the set of channels (from this User's inherited channels) that were granted at or
+// after the given sequence, so a replicator can backfill exactly what it's missing.
+func (user *User) ChannelsSince(since uint64) TimedSet {
+	result := make(TimedSet)
+	for channel, grant := range user.InheritedChannels() {
+		if grant.Sequence >= since {
+			result[channel] = grant.Sequence
+		}
+	}
+	return result
+}
+
+// Stamps every channel in user.Channels with its authoritative sequence: a channel whose
+// permission is unchanged from old (pre-save) carries its old sequence forward unchanged;
+// every other channel — new, or with a permission that changed — is treated as a fresh
+// grant and stamped with a new sequence from nextSequence, regardless of any Sequence value
+// the caller supplied (a user-update payload is client-controlled, and the server must be
+// the sole authority on grant sequence for ChannelsSince to be trustworthy). Called by
+// Authenticator.SaveUser implementations just before persisting.
+func stampChannelSequences(user, old *User, nextSequence func() (uint64, error)) error {
+	var oldChannels ChannelGrants
+	if old != nil {
+		oldChannels = old.Channels
+	}
+	for name, grant := range user.Channels {
+		if oldGrant, existed := oldChannels[name]; existed && oldGrant.Permission == grant.Permission {
+			grant.Sequence = oldGrant.Sequence
+		} else {
+			seq, err := nextSequence()
+			if err != nil {
+				return err
+			}
+			grant.Sequence = seq
+		}
+		user.Channels[name] = grant
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+//  Copyright (c) 2012 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package channelsync
+
+import "testing"
+
+// A client-supplied Sequence on a newly granted channel must be ignored; the server
+// allocates it via nextSequence regardless of what the caller sent.
+func TestStampChannelSequencesIgnoresClientSuppliedSequence(t *testing.T) {
+	user := &User{Channels: ChannelGrants{
+		"new": {Permission: PermissionReadWrite, Sequence: 99999},
+	}}
+	var allocated uint64
+	nextSequence := func() (uint64, error) {
+		allocated++
+		return allocated, nil
+	}
+	if err := stampChannelSequences(user, nil, nextSequence); err != nil {
+		t.Fatalf("stampChannelSequences failed: %v", err)
+	}
+	if got := user.Channels["new"].Sequence; got != 1 {
+		t.Errorf("expected server-allocated sequence 1, got %d (client-supplied value leaked through)", got)
+	}
+}
+
+// A channel whose permission is unchanged from the old document must keep its original
+// sequence, even if the incoming update tries to change it.
+func TestStampChannelSequencesPreservesUnchangedPermission(t *testing.T) {
+	old := &User{Channels: ChannelGrants{"existing": {Permission: PermissionReadWrite, Sequence: 5}}}
+	user := &User{Channels: ChannelGrants{"existing": {Permission: PermissionReadWrite, Sequence: 12345}}}
+	nextSequence := func() (uint64, error) {
+		t.Fatal("nextSequence should not be called for a channel whose permission didn't change")
+		return 0, nil
+	}
+	if err := stampChannelSequences(user, old, nextSequence); err != nil {
+		t.Fatalf("stampChannelSequences failed: %v", err)
+	}
+	if got := user.Channels["existing"].Sequence; got != 5 {
+		t.Errorf("expected preserved sequence 5, got %d", got)
+	}
+}
+
+// A channel whose permission changed from the old document (e.g. deny -> read-write) is a
+// new grant in effect, even though the channel name already existed, and must get a fresh
+// sequence so ChannelsSince reports it as newly accessible.
+func TestStampChannelSequencesReallocatesOnPermissionChange(t *testing.T) {
+	old := &User{Channels: ChannelGrants{"secret": {Permission: PermissionDeny, Sequence: 5}}}
+	user := &User{Channels: ChannelGrants{"secret": {Permission: PermissionReadWrite}}}
+	nextSequence := func() (uint64, error) { return 6, nil }
+	if err := stampChannelSequences(user, old, nextSequence); err != nil {
+		t.Fatalf("stampChannelSequences failed: %v", err)
+	}
+	if got := user.Channels["secret"].Sequence; got != 6 {
+		t.Errorf("expected freshly allocated sequence 6 when permission changed, got %d", got)
+	}
+}